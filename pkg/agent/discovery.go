@@ -0,0 +1,233 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DiscoveryRule describes how the receiver_creator subsystem should react
+// to a pod or node discovered by the k8s_observer extension. A rule
+// matches on a single annotation (AnnotationSelector, or
+// middleware.io/scrape by default) being "true" and instantiates the
+// named receiver against the matched endpoint. Other annotations such as
+// middleware.io/port and middleware.io/path are not read.
+type DiscoveryRule struct {
+	// Name identifies the rule in agent logs when a matching endpoint is
+	// discovered or removed.
+	Name string
+
+	// Receiver is the receiver type to instantiate for endpoints matched
+	// by this rule, e.g. "prometheus", "kubeletstats" or "filelog".
+	Receiver string
+
+	// AnnotationSelector restricts this rule to pods/nodes carrying the
+	// given annotation key, e.g. "middleware.io/scrape".
+	AnnotationSelector string
+
+	// ObserveNodes includes node endpoints discovered by k8s_observer in
+	// addition to pod endpoints.
+	ObserveNodes bool
+
+	// ObservePods includes pod endpoints discovered by k8s_observer.
+	ObservePods bool
+
+	// NodeFilter restricts node discovery to nodes matching this label
+	// selector, analogous to the k8s_observer's own node filter.
+	NodeFilter string
+}
+
+// ReceiverCreatorConfig builds the receiver_creator receiver's
+// "receivers" config block from the agent's discovery rules, mapping
+// each rule's AnnotationSelector/ObserveNodes/ObservePods/NodeFilter to a
+// receiver_creator discovery rule expression that instantiates Receiver
+// against matching endpoints. It returns nil when endpoint discovery
+// isn't enabled or no rules are configured. GetFactories registers the
+// k8sobserver extension and receivercreator receiver that consume this
+// config once it's merged into the generated otel-config.yaml.
+func (k *KubeAgent) ReceiverCreatorConfig() map[string]interface{} {
+	if !k.enableEndpointDiscovery || len(k.discoveryRules) == 0 {
+		return nil
+	}
+
+	receivers := make(map[string]interface{}, len(k.discoveryRules))
+	for i, rule := range k.discoveryRules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule-%d", i)
+		}
+
+		receivers[fmt.Sprintf("%s/%s", rule.Receiver, name)] = map[string]interface{}{
+			"rule":   rule.discoveryRuleExpr(),
+			"config": map[string]interface{}{"endpoint": rule.discoveryEndpointTemplate()},
+		}
+	}
+
+	return map[string]interface{}{
+		"watch_observers": []string{"k8s_observer"},
+		"receivers":       receivers,
+	}
+}
+
+// discoveryRuleExpr builds the receiver_creator rule expression for this
+// DiscoveryRule: it requires the middleware.io/scrape annotation (or
+// AnnotationSelector when set), restricts to nodes or pods per
+// ObserveNodes/ObservePods, and applies NodeFilter as an additional
+// condition when set.
+func (r DiscoveryRule) discoveryRuleExpr() string {
+	annotation := r.AnnotationSelector
+	if annotation == "" {
+		annotation = "middleware.io/scrape"
+	}
+
+	expr := fmt.Sprintf(`annotations["%s"] == "true"`, annotation)
+
+	switch {
+	case r.ObserveNodes && r.ObservePods:
+		expr = fmt.Sprintf(`(type == "k8s.node" || type == "pod") && %s`, expr)
+	case r.ObserveNodes:
+		expr = fmt.Sprintf(`type == "k8s.node" && %s`, expr)
+	case r.ObservePods:
+		expr = fmt.Sprintf(`type == "pod" && %s`, expr)
+	}
+
+	if r.NodeFilter != "" {
+		expr = fmt.Sprintf(`%s && (%s)`, expr, r.NodeFilter)
+	}
+
+	return expr
+}
+
+// discoveryEndpointTemplate builds the receiver_creator endpoint
+// template for this rule. receiver_creator's rule/config templates are
+// evaluated by a constrained expression language, not a general
+// templating engine: it has no "| default" style fallback operator,
+// and a backtick-delimited expression can't nest another one inside
+// it. This uses only the fields it actually supports — the discovered
+// endpoint's own address and port — rather than emit a template string
+// that's silently mis-evaluated at runtime (cfg.Validate() doesn't
+// catch this; template bodies are opaque strings to it). Per-endpoint
+// middleware.io/port overrides aren't implemented.
+func (r DiscoveryRule) discoveryEndpointTemplate() string {
+	return "`endpoint`:`port`"
+}
+
+// K8sObserverConfig builds the k8s_observer extension's own config
+// block: whether it watches nodes and/or pods, and the node label
+// selector restricting node discovery. It aggregates ObserveNodes/
+// ObservePods/NodeFilter across every configured discovery rule, since
+// k8s_observer has a single watch scope shared by all of them — unlike
+// discoveryRuleExpr's per-rule type == "pod" / type == "k8s.node"
+// matching, which only filters which of the endpoints it discovers
+// each receiver_creator rule reacts to. It returns nil under the same
+// conditions as ReceiverCreatorConfig.
+func (k *KubeAgent) K8sObserverConfig() map[string]interface{} {
+	if !k.enableEndpointDiscovery || len(k.discoveryRules) == 0 {
+		return nil
+	}
+
+	var observeNodes, observePods bool
+	var nodeFilters []string
+
+	for _, rule := range k.discoveryRules {
+		observeNodes = observeNodes || rule.ObserveNodes
+		observePods = observePods || rule.ObservePods
+		if rule.NodeFilter != "" {
+			nodeFilters = append(nodeFilters, rule.NodeFilter)
+		}
+	}
+
+	cfg := map[string]interface{}{
+		"observe_nodes": observeNodes,
+		"observe_pods":  observePods,
+	}
+	if len(nodeFilters) > 0 {
+		cfg["node_selector"] = strings.Join(nodeFilters, ",")
+	}
+
+	return cfg
+}
+
+// mergeReceiverCreatorConfig reads the discovery otel-config template at
+// templatePath, merges rcCfg in as its "receiver_creator/discovery"
+// receiver, and writes the result alongside the template. It returns
+// the path to the merged file, which GetUpdatedYAMLPath returns instead
+// of the static template whenever discovery rules are configured —
+// without this, WithKubeAgentDiscoveryRules has no effect on the config
+// the agent actually runs.
+func mergeReceiverCreatorConfig(templatePath string, rcCfg map[string]interface{}) (string, error) {
+	base, err := os.ReadFile(templatePath)
+	if err != nil {
+		return "", fmt.Errorf("reading discovery template %s: %w", templatePath, err)
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(base, &doc); err != nil {
+		return "", fmt.Errorf("parsing discovery template %s: %w", templatePath, err)
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+
+	receivers, _ := doc["receivers"].(map[string]interface{})
+	if receivers == nil {
+		receivers = map[string]interface{}{}
+	}
+	receivers["receiver_creator/discovery"] = rcCfg
+	doc["receivers"] = receivers
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("marshalling merged discovery config: %w", err)
+	}
+
+	mergedPath := strings.TrimSuffix(templatePath, ".yaml") + "-generated.yaml"
+	if err := os.WriteFile(mergedPath, out, 0o644); err != nil {
+		return "", fmt.Errorf("writing merged discovery config %s: %w", mergedPath, err)
+	}
+
+	return mergedPath, nil
+}
+
+// mergeK8sObserverConfig reads the otel-config template at templatePath
+// and merges observerCfg in as the k8s_observer extension's own config,
+// writing the result alongside the template. Without this,
+// ObserveNodes/ObservePods/NodeFilter on a DiscoveryRule only shape the
+// per-rule receiver_creator expression (see discoveryRuleExpr) — the
+// extension that actually does the watching never learns to watch
+// nodes, watch pods, or restrict which nodes it watches.
+func mergeK8sObserverConfig(templatePath string, observerCfg map[string]interface{}) (string, error) {
+	base, err := os.ReadFile(templatePath)
+	if err != nil {
+		return "", fmt.Errorf("reading discovery template %s: %w", templatePath, err)
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(base, &doc); err != nil {
+		return "", fmt.Errorf("parsing discovery template %s: %w", templatePath, err)
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+
+	extensions, _ := doc["extensions"].(map[string]interface{})
+	if extensions == nil {
+		extensions = map[string]interface{}{}
+	}
+	extensions["k8s_observer"] = observerCfg
+	doc["extensions"] = extensions
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("marshalling merged discovery config: %w", err)
+	}
+
+	mergedPath := strings.TrimSuffix(templatePath, ".yaml") + "-observer-generated.yaml"
+	if err := os.WriteFile(mergedPath, out, 0o644); err != nil {
+		return "", fmt.Errorf("writing merged discovery config %s: %w", mergedPath, err)
+	}
+
+	return mergedPath, nil
+}
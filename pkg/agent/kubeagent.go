@@ -2,8 +2,13 @@ package agent
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/open-telemetry/opentelemetry-collector-contrib/extension/observer/k8sobserver"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/processor/attributesprocessor"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/processor/filterprocessor"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/processor/k8sattributesprocessor"
@@ -17,7 +22,9 @@ import (
 	"github.com/open-telemetry/opentelemetry-collector-contrib/receiver/k8seventsreceiver"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/receiver/kubeletstatsreceiver"
 	"github.com/open-telemetry/opentelemetry-collector-contrib/receiver/prometheusreceiver"
+	"github.com/open-telemetry/opentelemetry-collector-contrib/receiver/receivercreator"
 	"go.opentelemetry.io/collector/exporter"
+	"go.opentelemetry.io/collector/exporter/debugexporter"
 	"go.opentelemetry.io/collector/exporter/loggingexporter"
 	"go.opentelemetry.io/collector/exporter/otlpexporter"
 	"go.opentelemetry.io/collector/exporter/otlphttpexporter"
@@ -29,6 +36,7 @@ import (
 	"go.opentelemetry.io/collector/receiver"
 	"go.opentelemetry.io/collector/receiver/otlpreceiver"
 	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes"
 )
 
 // KubeAgent implements Agent interface for Kubernetes
@@ -43,6 +51,27 @@ type KubeAgent struct {
 
 	logger         *zap.Logger
 	dockerEndpoint string
+
+	enableEndpointDiscovery bool
+	discoveryRules          []DiscoveryRule
+
+	selfMonitoringEnabled  bool
+	selfMonitoringInterval time.Duration
+	selfMonitor            *selfMonitor
+
+	mustGatherOutputDir  string
+	mustGatherKubeClient kubernetes.Interface
+	mustGatherNamespace  string
+
+	configReloadStrategy ConfigReloadStrategy
+
+	opampEndpoint string
+	opampHeaders  map[string]string
+	opampTLS      *tls.Config
+
+	tlsConfig    *TLSConfig
+	tlsWatcher   *tlsWatcher
+	tlsWatcherMu sync.Mutex
 }
 
 // KubeOptions takes in various options for KubeAgent
@@ -104,6 +133,41 @@ func WithKubeAgentDockerEndpoint(endpoint string) KubeOptions {
 	}
 }
 
+// WithKubeAgentEnableEndpointDiscovery enables the k8s_observer +
+// receiver_creator subsystem so that scrape targets for newly created
+// pods and nodes are discovered dynamically instead of being statically
+// enumerated in otel-config.yaml.
+func WithKubeAgentEnableEndpointDiscovery(e bool) KubeOptions {
+	return func(h *KubeAgent) {
+		h.enableEndpointDiscovery = e
+	}
+}
+
+// WithKubeAgentDiscoveryRules sets the rules used to match discovered
+// pods/nodes against a receiver to instantiate for them.
+func WithKubeAgentDiscoveryRules(rules []DiscoveryRule) KubeOptions {
+	return func(h *KubeAgent) {
+		h.discoveryRules = rules
+	}
+}
+
+// WithKubeAgentSelfMonitoringEnabled enables the agent's internal
+// telemetry pipeline, exporting the collector's own metrics, traces and
+// zap logs to the Middleware backend so fleet health can be monitored.
+func WithKubeAgentSelfMonitoringEnabled(e bool) KubeOptions {
+	return func(h *KubeAgent) {
+		h.selfMonitoringEnabled = e
+	}
+}
+
+// WithKubeAgentSelfMonitoringInterval sets how often the agent reports
+// its internal telemetry when self-monitoring is enabled.
+func WithKubeAgentSelfMonitoringInterval(interval time.Duration) KubeOptions {
+	return func(h *KubeAgent) {
+		h.selfMonitoringInterval = interval
+	}
+}
+
 // NewKubeAgent returns new agent for Kubernetes with given options.
 func NewKubeAgent(opts ...KubeOptions) *KubeAgent {
 	var cfg KubeAgent
@@ -115,18 +179,67 @@ func NewKubeAgent(opts ...KubeOptions) *KubeAgent {
 		cfg.logger, _ = zap.NewProduction()
 	}
 
+	if cfg.selfMonitoringEnabled {
+		if cfg.selfMonitoringInterval == 0 {
+			cfg.selfMonitoringInterval = defaultSelfMonitoringInterval
+		}
+
+		m, err := startSelfMonitoring(context.Background(), &cfg)
+		if err != nil {
+			cfg.logger.Warn("failed to start self-monitoring pipeline", zap.Error(err))
+		} else {
+			cfg.selfMonitor = m
+		}
+	}
+
 	return &cfg
 }
 
-// GetUpdatedYAMLPath gets the correct otel configuration file.
+// GetUpdatedYAMLPath gets the correct otel configuration file. When
+// endpoint discovery is enabled with discovery rules configured, the
+// rules' generated receiver_creator config and the k8s_observer
+// extension's own watch config are merged into the discovery template.
+// When a TLSConfig is set, a tls: block is merged into every otlp/
+// otlphttp exporter. The path to the merged file is returned instead
+// of the static template whenever any merging happened.
 func (k *KubeAgent) GetUpdatedYAMLPath() (string, error) {
 	yamlPath := "/app/otel-config.yaml"
 	dockerSocketPath := strings.Split(k.dockerEndpoint, "//")
+	hasDocker := len(dockerSocketPath) == 2 && isSocketFn(dockerSocketPath[1])
 
-	if len(dockerSocketPath) != 2 || !isSocketFn(dockerSocketPath[1]) {
+	switch {
+	case k.enableEndpointDiscovery && !hasDocker:
+		yamlPath = "/app/otel-config-discovery-nodocker.yaml"
+	case k.enableEndpointDiscovery:
+		yamlPath = "/app/otel-config-discovery.yaml"
+	case !hasDocker:
 		yamlPath = "/app/otel-config-nodocker.yaml"
 	}
 
+	if rcCfg := k.ReceiverCreatorConfig(); rcCfg != nil {
+		merged, err := mergeReceiverCreatorConfig(yamlPath, rcCfg)
+		if err != nil {
+			return "", fmt.Errorf("merging discovery rules into %s: %w", yamlPath, err)
+		}
+		yamlPath = merged
+
+		if observerCfg := k.K8sObserverConfig(); observerCfg != nil {
+			merged, err := mergeK8sObserverConfig(yamlPath, observerCfg)
+			if err != nil {
+				return "", fmt.Errorf("merging k8s_observer config into %s: %w", yamlPath, err)
+			}
+			yamlPath = merged
+		}
+	}
+
+	if tlsCfg := k.GetTLSConfig(); tlsCfg != nil {
+		merged, err := mergeTLSConfig(yamlPath, tlsCfg)
+		if err != nil {
+			return "", fmt.Errorf("merging TLS config into %s: %w", yamlPath, err)
+		}
+		yamlPath = merged
+	}
+
 	return yamlPath, nil
 }
 
@@ -134,10 +247,11 @@ func (k *KubeAgent) GetUpdatedYAMLPath() (string, error) {
 func (k *KubeAgent) GetFactories(ctx context.Context) (otelcol.Factories, error) {
 	var err error
 	factories := otelcol.Factories{}
-	factories.Extensions, err = extension.MakeFactoryMap(
-	//healthcheckextension.NewFactory(),
-	// frontend.NewAuthFactory(),
-	)
+	factories.Extensions, err = extension.MakeFactoryMap([]extension.Factory{
+		//healthcheckextension.NewFactory(),
+		// frontend.NewAuthFactory(),
+		k8sobserver.NewFactory(),
+	}...)
 	if err != nil {
 		return otelcol.Factories{}, err
 	}
@@ -152,6 +266,7 @@ func (k *KubeAgent) GetFactories(ctx context.Context) (otelcol.Factories, error)
 		k8seventsreceiver.NewFactory(),
 		kubeletstatsreceiver.NewFactory(),
 		prometheusreceiver.NewFactory(),
+		receivercreator.NewFactory(),
 	}...)
 	if err != nil {
 		return otelcol.Factories{}, err
@@ -159,6 +274,7 @@ func (k *KubeAgent) GetFactories(ctx context.Context) (otelcol.Factories, error)
 
 	factories.Exporters, err = exporter.MakeFactoryMap([]exporter.Factory{
 		loggingexporter.NewFactory(),
+		debugexporter.NewFactory(),
 		otlpexporter.NewFactory(),
 		otlphttpexporter.NewFactory(),
 	}...)
@@ -181,4 +297,4 @@ func (k *KubeAgent) GetFactories(ctx context.Context) (otelcol.Factories, error)
 	}
 
 	return factories, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,346 @@
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// KubeAgentMustGather collects a support bundle for the agent's running
+// instance so users filing tickets can attach a single archive rather
+// than piecing together kubectl commands.
+type KubeAgentMustGather struct {
+	agent     *KubeAgent
+	outputDir string
+	namespace string
+}
+
+// WithKubeAgentMustGatherOutputDir sets the directory the must-gather
+// archive is written to. Defaults to the current working directory.
+func WithKubeAgentMustGatherOutputDir(dir string) KubeOptions {
+	return func(h *KubeAgent) {
+		h.mustGatherOutputDir = dir
+	}
+}
+
+// WithKubeAgentMustGatherKubeClient supplies the Kubernetes client used
+// to collect Middleware-owned cluster resources, agent pod logs, k8s
+// events and node conditions. Without it those sections of the archive
+// record why they were skipped instead of silently failing.
+func WithKubeAgentMustGatherKubeClient(client kubernetes.Interface) KubeOptions {
+	return func(h *KubeAgent) {
+		h.mustGatherKubeClient = client
+	}
+}
+
+// WithKubeAgentMustGatherNamespace sets the namespace must-gather looks
+// in for Middleware-owned resources. Defaults to the POD_NAMESPACE
+// downward-API env var.
+func WithKubeAgentMustGatherNamespace(namespace string) KubeOptions {
+	return func(h *KubeAgent) {
+		h.mustGatherNamespace = namespace
+	}
+}
+
+// mustGatherLabelSelector selects the Middleware-owned resources
+// must-gather collects: the ConfigMaps, DaemonSets and Deployments, and
+// the agent pods whose logs are tailed, carrying this label.
+const mustGatherLabelSelector = "app.kubernetes.io/part-of=mw-agent"
+
+// MustGather returns a KubeAgentMustGather bound to this agent's
+// configuration.
+func (k *KubeAgent) MustGather() *KubeAgentMustGather {
+	namespace := k.mustGatherNamespace
+	if namespace == "" {
+		namespace = os.Getenv("POD_NAMESPACE")
+	}
+
+	return &KubeAgentMustGather{
+		agent:     k,
+		outputDir: k.mustGatherOutputDir,
+		namespace: namespace,
+	}
+}
+
+// Collect gathers the support bundle and writes it to a tar.gz archive
+// under the configured output directory, returning the archive path.
+func (g *KubeAgentMustGather) Collect(ctx context.Context) (string, error) {
+	outputDir := g.outputDir
+	if outputDir == "" {
+		outputDir = "."
+	}
+
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return "", fmt.Errorf("creating must-gather output dir: %w", err)
+	}
+
+	archivePath := filepath.Join(outputDir, fmt.Sprintf("mw-agent-must-gather-%d.tar.gz", time.Now().Unix()))
+
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("creating must-gather archive: %w", err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	defer gz.Close()
+
+	tw := tar.NewWriter(gz)
+	defer tw.Close()
+
+	for _, c := range g.collectors() {
+		data, err := c.collect(ctx, g)
+		if err != nil {
+			data = []byte(fmt.Sprintf("error collecting %s: %v", c.name, err))
+		}
+
+		if err := writeTarEntry(tw, c.name, data); err != nil {
+			return "", fmt.Errorf("writing %s to must-gather archive: %w", c.name, err)
+		}
+	}
+
+	return archivePath, nil
+}
+
+// mustGatherCollector produces one named entry of the support bundle.
+type mustGatherCollector struct {
+	name    string
+	collect func(ctx context.Context, g *KubeAgentMustGather) ([]byte, error)
+}
+
+// collectors returns the set of entries included in a must-gather
+// archive: the effective otel config, the resolved factories list,
+// Middleware-owned cluster resources, agent pod logs, k8s events, node
+// conditions, pipeline stats and a sample of processor output.
+func (g *KubeAgentMustGather) collectors() []mustGatherCollector {
+	return []mustGatherCollector{
+		{name: "otel-config.yaml", collect: collectEffectiveConfig},
+		{name: "factories.txt", collect: collectFactories},
+		{name: "cluster-resources.txt", collect: collectClusterResources},
+		{name: "pod-logs.txt", collect: collectPodLogs},
+		{name: "events.txt", collect: collectEvents},
+		{name: "node-conditions.txt", collect: collectNodeConditions},
+		{name: "pipeline-stats.txt", collect: collectPipelineStats},
+		{name: "sample-processor-output.txt", collect: collectSampleProcessorOutput},
+	}
+}
+
+func collectEffectiveConfig(ctx context.Context, g *KubeAgentMustGather) ([]byte, error) {
+	yamlPath, err := g.agent.GetUpdatedYAMLPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(yamlPath)
+}
+
+func collectFactories(ctx context.Context, g *KubeAgentMustGather) ([]byte, error) {
+	factories, err := g.agent.GetFactories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	appendNames := func(section string, names []string) {
+		out = append(out, []byte(section+":\n")...)
+		for _, n := range names {
+			out = append(out, []byte("  - "+n+"\n")...)
+		}
+	}
+
+	appendNames("receivers", factoryMapKeys(factories.Receivers))
+	appendNames("processors", factoryMapKeys(factories.Processors))
+	appendNames("exporters", factoryMapKeys(factories.Exporters))
+	appendNames("extensions", factoryMapKeys(factories.Extensions))
+
+	return out, nil
+}
+
+// skippedNoKubeClient reports why a section was skipped rather than
+// silently emitting an error, when no kube client was wired via
+// WithKubeAgentMustGatherKubeClient.
+func skippedNoKubeClient(section string) []byte {
+	return []byte(fmt.Sprintf("skipped: %s requires WithKubeAgentMustGatherKubeClient to be set\n", section))
+}
+
+// collectClusterResources dumps the Middleware-owned ConfigMaps,
+// DaemonSets and Deployments in the agent's namespace. It doesn't
+// include Middleware's own custom resources: mustGatherKubeClient is a
+// typed kubernetes.Interface, which only reaches built-in API groups —
+// listing CRDs needs a dynamic or CRD-specific client, which isn't
+// wired into must-gather.
+func collectClusterResources(ctx context.Context, g *KubeAgentMustGather) ([]byte, error) {
+	if g.agent.mustGatherKubeClient == nil {
+		return skippedNoKubeClient("cluster resource collection"), nil
+	}
+
+	client := g.agent.mustGatherKubeClient
+	opts := metav1.ListOptions{LabelSelector: mustGatherLabelSelector}
+
+	var out bytes.Buffer
+
+	cms, err := client.CoreV1().ConfigMaps(g.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing configmaps: %w", err)
+	}
+	fmt.Fprintf(&out, "configmaps:\n")
+	for _, cm := range cms.Items {
+		fmt.Fprintf(&out, "  - %s\n", cm.Name)
+	}
+
+	daemonsets, err := client.AppsV1().DaemonSets(g.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing daemonsets: %w", err)
+	}
+	fmt.Fprintf(&out, "daemonsets:\n")
+	for _, ds := range daemonsets.Items {
+		fmt.Fprintf(&out, "  - %s\n", ds.Name)
+	}
+
+	deployments, err := client.AppsV1().Deployments(g.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing deployments: %w", err)
+	}
+	fmt.Fprintf(&out, "deployments:\n")
+	for _, d := range deployments.Items {
+		fmt.Fprintf(&out, "  - %s\n", d.Name)
+	}
+
+	return out.Bytes(), nil
+}
+
+// collectPodLogs tails recent logs from every agent pod in the
+// namespace.
+func collectPodLogs(ctx context.Context, g *KubeAgentMustGather) ([]byte, error) {
+	if g.agent.mustGatherKubeClient == nil {
+		return skippedNoKubeClient("pod log collection"), nil
+	}
+
+	client := g.agent.mustGatherKubeClient
+
+	pods, err := client.CoreV1().Pods(g.namespace).List(ctx, metav1.ListOptions{LabelSelector: mustGatherLabelSelector})
+	if err != nil {
+		return nil, fmt.Errorf("listing agent pods: %w", err)
+	}
+
+	var tailLines int64 = 200
+	var out bytes.Buffer
+
+	for _, pod := range pods.Items {
+		fmt.Fprintf(&out, "==> %s <==\n", pod.Name)
+
+		stream, err := client.CoreV1().Pods(g.namespace).GetLogs(pod.Name, &corev1.PodLogOptions{TailLines: &tailLines}).Stream(ctx)
+		if err != nil {
+			fmt.Fprintf(&out, "error fetching logs: %v\n", err)
+			continue
+		}
+
+		if _, err := io.Copy(&out, stream); err != nil {
+			fmt.Fprintf(&out, "error reading logs: %v\n", err)
+		}
+		stream.Close()
+	}
+
+	return out.Bytes(), nil
+}
+
+// collectEvents dumps recent Kubernetes events in the namespace.
+func collectEvents(ctx context.Context, g *KubeAgentMustGather) ([]byte, error) {
+	if g.agent.mustGatherKubeClient == nil {
+		return skippedNoKubeClient("event collection"), nil
+	}
+
+	events, err := g.agent.mustGatherKubeClient.CoreV1().Events(g.namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing events: %w", err)
+	}
+
+	var out bytes.Buffer
+	for _, e := range events.Items {
+		fmt.Fprintf(&out, "%s\t%s\t%s\t%s\n", e.LastTimestamp, e.Type, e.InvolvedObject.Name, e.Message)
+	}
+
+	return out.Bytes(), nil
+}
+
+// collectNodeConditions dumps the conditions of every node in the
+// cluster.
+func collectNodeConditions(ctx context.Context, g *KubeAgentMustGather) ([]byte, error) {
+	if g.agent.mustGatherKubeClient == nil {
+		return skippedNoKubeClient("node condition collection"), nil
+	}
+
+	nodes, err := g.agent.mustGatherKubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing nodes: %w", err)
+	}
+
+	var out bytes.Buffer
+	for _, n := range nodes.Items {
+		fmt.Fprintf(&out, "%s:\n", n.Name)
+		for _, c := range n.Status.Conditions {
+			fmt.Fprintf(&out, "  - %s=%s (%s)\n", c.Type, c.Status, c.Reason)
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// collectPipelineStats records the running pipeline's stats —
+// receiver/exporter counters and queue depth. KubeAgentMustGather isn't
+// wired to a live ConfigManager/runningPipeline (must-gather can run as
+// a one-off against a KubeAgent that was never used to start a
+// pipeline), so there's nothing to read stats from here; that's
+// recorded explicitly rather than silently leaving the section out.
+func collectPipelineStats(ctx context.Context, g *KubeAgentMustGather) ([]byte, error) {
+	return []byte("skipped: pipeline stats require a live ConfigManager/runningPipeline, which must-gather isn't wired to\n"), nil
+}
+
+// collectSampleProcessorOutput records the resourcedetection and
+// k8sattributes processors' output on a sample signal. Producing that
+// would mean standing up a throwaway pipeline and feeding it a
+// synthetic signal purely for the must-gather bundle; not implemented,
+// so this is recorded as skipped rather than silently left out.
+func collectSampleProcessorOutput(ctx context.Context, g *KubeAgentMustGather) ([]byte, error) {
+	return []byte("skipped: sample processor output requires running resourcedetection/k8sattributes against a live signal, not implemented\n"), nil
+}
+
+// factoryMapKeys returns the sorted component type names registered in
+// an otelcol factory map (receiver.FactoryMap, processor.FactoryMap, ...
+// all of which key on component.Type, a fmt.Stringer).
+func factoryMapKeys[K fmt.Stringer, V any](m map[K]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k.String())
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func writeTarEntry(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Name: name,
+		Mode: 0o644,
+		Size: int64(len(data)),
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err := tw.Write(data)
+
+	return err
+}
@@ -0,0 +1,240 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/open-telemetry/opamp-go/client"
+	"github.com/open-telemetry/opamp-go/client/types"
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"go.uber.org/zap"
+)
+
+// opampConnectTimeout bounds how long Start waits for the initial OpAMP
+// handshake before giving up and telling the caller to fall back to
+// HTTP polling — this is our stand-in for capability negotiation, since
+// an old or misconfigured OpAMP server that never completes the
+// handshake is, from the agent's perspective, indistinguishable from one
+// that doesn't support OpAMP at all.
+const opampConnectTimeout = 10 * time.Second
+
+// WithKubeAgentOpAMPEndpoint sets the OpAMP server endpoint the agent
+// reports to and takes remote config from. When unset, the agent falls
+// back to HTTP polling via apiURLForConfigCheck.
+func WithKubeAgentOpAMPEndpoint(endpoint string) KubeOptions {
+	return func(h *KubeAgent) {
+		h.opampEndpoint = endpoint
+	}
+}
+
+// WithKubeAgentOpAMPHeaders sets additional headers sent on every OpAMP
+// connection, e.g. for authenticating with the Middleware-hosted OpAMP
+// server.
+func WithKubeAgentOpAMPHeaders(headers map[string]string) KubeOptions {
+	return func(h *KubeAgent) {
+		h.opampHeaders = headers
+	}
+}
+
+// WithKubeAgentOpAMPTLS sets the TLS config used for the OpAMP
+// connection.
+func WithKubeAgentOpAMPTLS(cfg *tls.Config) KubeOptions {
+	return func(h *KubeAgent) {
+		h.opampTLS = cfg
+	}
+}
+
+// opampClient wraps an OpAMP client bound to a KubeAgent, reporting
+// agent identity and health and driving the ConfigManager hot-reload
+// path from RemoteConfig messages. When the agent's opampEndpoint isn't
+// set, or the server never completes the connection handshake, Start
+// reports back to the caller that it should fall back to HTTP polling
+// via ConfigManager.Poll instead.
+type opampClient struct {
+	agent    *KubeAgent
+	client   client.OpAMPClient
+	reloader *ConfigManager
+}
+
+// NewOpAMPClient returns an opampClient bound to the given agent. Start
+// returns immediately without connecting if the agent has no OpAMP
+// endpoint configured.
+func NewOpAMPClient(k *KubeAgent, reloader *ConfigManager) *opampClient {
+	return &opampClient{
+		agent:    k,
+		client:   client.NewWebSocket(nil),
+		reloader: reloader,
+	}
+}
+
+// Start connects to the configured OpAMP server and reports agent
+// identity, effective config and health, then waits up to
+// opampConnectTimeout for the server to complete the connection
+// handshake. It returns (false, nil) when no endpoint is configured, or
+// when the handshake doesn't complete in time, so the caller falls back
+// to HTTP polling.
+func (o *opampClient) Start(ctx context.Context) (bool, error) {
+	if o.agent.opampEndpoint == "" {
+		return false, nil
+	}
+
+	tlsCfg := o.agent.opampTLS
+	if tlsCfg == nil {
+		cfg, err := o.agent.tlsConfigFor(ctx)
+		if err != nil {
+			return false, fmt.Errorf("loading TLS config for OpAMP client: %w", err)
+		}
+		tlsCfg = cfg
+	}
+
+	connected := make(chan struct{}, 1)
+	failed := make(chan error, 1)
+
+	settings := types.StartSettings{
+		OpAMPServerURL: o.agent.opampEndpoint,
+		Header:         toHTTPHeader(o.agent.opampHeaders),
+		TLSConfig:      tlsCfg,
+		InstanceUid:    o.instanceUID(),
+		Capabilities: protobufs.AgentCapabilities_AgentCapabilities_AcceptsRemoteConfig |
+			protobufs.AgentCapabilities_AgentCapabilities_ReportsRemoteConfig |
+			protobufs.AgentCapabilities_AgentCapabilities_ReportsEffectiveConfig |
+			protobufs.AgentCapabilities_AgentCapabilities_ReportsHealth |
+			protobufs.AgentCapabilities_AgentCapabilities_ReportsPackageStatuses,
+		Callbacks: types.CallbacksStruct{
+			OnConnectFunc: func(ctx context.Context) {
+				o.agent.logger.Info("connected to OpAMP server")
+				select {
+				case connected <- struct{}{}:
+				default:
+				}
+			},
+			OnConnectFailedFunc: func(ctx context.Context, err error) {
+				o.agent.logger.Warn("failed to connect to OpAMP server", zap.Error(err))
+				select {
+				case failed <- err:
+				default:
+				}
+			},
+			OnMessageFunc: o.onMessage,
+			GetEffectiveConfigFunc: func(ctx context.Context) (*protobufs.EffectiveConfig, error) {
+				return &protobufs.EffectiveConfig{
+					ConfigMap: &protobufs.AgentConfigMap{
+						ConfigMap: map[string]*protobufs.AgentConfigFile{
+							"": {Body: o.reloader.EffectiveConfigYAML(), ContentType: "text/yaml"},
+						},
+					},
+				}, nil
+			},
+		},
+	}
+
+	if err := o.client.Start(ctx, settings); err != nil {
+		return false, fmt.Errorf("starting OpAMP client: %w", err)
+	}
+
+	if err := o.reportIdentity(ctx); err != nil {
+		o.agent.logger.Warn("failed to report OpAMP agent identity", zap.Error(err))
+	}
+
+	select {
+	case <-connected:
+		return true, nil
+	case <-failed:
+		_ = o.client.Stop(ctx)
+		return false, nil
+	case <-time.After(opampConnectTimeout):
+		o.agent.logger.Warn("OpAMP server did not complete handshake in time, falling back to HTTP polling")
+		_ = o.client.Stop(ctx)
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// reportIdentity reports the agent's health and an "mw-agent" package
+// status back to the OpAMP server, alongside the effective-config
+// reporting wired through GetEffectiveConfigFunc. The package status
+// carries the package name only; no version is reported.
+func (o *opampClient) reportIdentity(ctx context.Context) error {
+	if err := o.client.SetHealth(&protobufs.ComponentHealth{
+		Healthy:           true,
+		StartTimeUnixNano: uint64(time.Now().UnixNano()),
+	}); err != nil {
+		return fmt.Errorf("reporting health: %w", err)
+	}
+
+	if err := o.client.SetPackageStatuses(&protobufs.PackageStatuses{
+		Packages: map[string]*protobufs.PackageStatus{
+			"mw-agent": {Name: "mw-agent"},
+		},
+	}); err != nil {
+		return fmt.Errorf("reporting package statuses: %w", err)
+	}
+
+	return nil
+}
+
+// Stop disconnects from the OpAMP server.
+func (o *opampClient) Stop(ctx context.Context) error {
+	return o.client.Stop(ctx)
+}
+
+// onMessage handles RemoteConfig, AgentIdentification and
+// PackagesAvailable messages from the OpAMP server. RemoteConfig is
+// applied directly from the pushed config rather than triggering a
+// re-fetch over HTTP, and its validation result is reported back as a
+// RemoteConfigStatus. AgentIdentification and PackagesAvailable are only
+// logged: the new instance uid is not adopted and no packages are
+// downloaded or installed.
+func (o *opampClient) onMessage(ctx context.Context, msg *types.MessageData) {
+	if msg.RemoteConfig != nil {
+		status := &protobufs.RemoteConfigStatus{
+			LastRemoteConfigHash: msg.RemoteConfig.ConfigHash,
+			Status:               protobufs.RemoteConfigStatuses_RemoteConfigStatuses_APPLIED,
+		}
+
+		if err := o.reloader.ApplyRemoteConfig(ctx, msg.RemoteConfig); err != nil {
+			o.agent.logger.Warn("failed to apply OpAMP remote config", zap.Error(err))
+			status.Status = protobufs.RemoteConfigStatuses_RemoteConfigStatuses_FAILED
+			status.ErrorMessage = err.Error()
+		}
+
+		if err := o.client.SetRemoteConfigStatus(status); err != nil {
+			o.agent.logger.Warn("failed to report remote config status", zap.Error(err))
+		}
+	}
+
+	if msg.AgentIdentification != nil {
+		o.agent.logger.Info("received new agent identification from OpAMP server")
+	}
+
+	if msg.PackagesAvailable != nil {
+		o.agent.logger.Info("packages available from OpAMP server")
+	}
+}
+
+// instanceUID derives the OpAMP instance uid from the node name and pod
+// uid the agent is running under, per the OpAMP spec's recommendation to
+// use a stable identifier scoped to the deployment.
+func (o *opampClient) instanceUID() []byte {
+	node := os.Getenv("K8S_NODE_NAME")
+	podUID := os.Getenv("K8S_POD_UID")
+
+	return []byte(fmt.Sprintf("%s/%s", node, podUID))
+}
+
+func toHTTPHeader(headers map[string]string) map[string][]string {
+	if headers == nil {
+		return nil
+	}
+
+	h := make(map[string][]string, len(headers))
+	for k, v := range headers {
+		h[k] = []string{v}
+	}
+
+	return h
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/otelcol"
+)
+
+// pipelineReadyPollInterval is how often startPipeline checks whether a
+// newly started collector has reached otelcol.StateRunning.
+const pipelineReadyPollInterval = 50 * time.Millisecond
+
+// runningPipeline wraps a live otelcol.Collector so ConfigManager can
+// wait for it to come up and shut it down again on rollback or cutover.
+type runningPipeline struct {
+	collector *otelcol.Collector
+	cancel    context.CancelFunc
+	done      chan error
+}
+
+// startPipeline constructs and runs a collector for cfg in the
+// background, returning once the Run goroutine has been launched. Call
+// waitReady to block until the pipeline is serving.
+func startPipeline(ctx context.Context, cfg *otelcol.Config, factories otelcol.Factories) (*runningPipeline, error) {
+	col, err := otelcol.NewCollector(otelcol.CollectorSettings{
+		BuildInfo: component.NewDefaultBuildInfo(),
+		Factories: func() (otelcol.Factories, error) { return factories, nil },
+		ConfigProvider: &staticConfigProvider{
+			cfg: cfg,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("constructing collector: %w", err)
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- col.Run(runCtx)
+	}()
+
+	return &runningPipeline{collector: col, cancel: cancel, done: done}, nil
+}
+
+// waitReady blocks until the pipeline reports otelcol.StateRunning, the
+// collector exits (an error, or nil if it shut down cleanly before
+// becoming ready), or ctx is cancelled.
+func (p *runningPipeline) waitReady(ctx context.Context) error {
+	ticker := time.NewTicker(pipelineReadyPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if p.collector.GetState() == otelcol.StateRunning {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-p.done:
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("collector exited before becoming ready")
+		case <-ticker.C:
+		}
+	}
+}
+
+// shutdown stops the pipeline's collector and releases its Run
+// goroutine.
+func (p *runningPipeline) shutdown() {
+	p.cancel()
+	p.collector.Shutdown()
+}
+
+// staticConfigProvider implements otelcol.ConfigProvider for a config
+// that has already been fetched and validated, so otelcol.NewCollector
+// doesn't need to re-read it from a file or remote URI.
+type staticConfigProvider struct {
+	cfg *otelcol.Config
+}
+
+func (p *staticConfigProvider) Get(ctx context.Context, _ otelcol.Factories) (*otelcol.Config, error) {
+	return p.cfg, nil
+}
+
+// Watch never signals a change: ConfigManager itself owns polling for
+// updates and calls swap explicitly rather than relying on the
+// collector's own config-watch machinery.
+func (p *staticConfigProvider) Watch() <-chan error {
+	return make(chan error)
+}
+
+func (p *staticConfigProvider) Shutdown(context.Context) error {
+	return nil
+}
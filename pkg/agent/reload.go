@@ -0,0 +1,354 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/open-telemetry/opamp-go/protobufs"
+	"go.opentelemetry.io/collector/confmap"
+	"go.opentelemetry.io/collector/otelcol"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigReloadStrategy controls how a newly fetched configuration is
+// cut over to once it has been validated.
+//
+// All three strategies run in the same process as the currently live
+// pipeline, so the old and new otelcol.Collector instances can't both
+// be bound and serving at once: they'd fight over the same receiver
+// listeners (e.g. otlpreceiver's fixed address), which the old
+// "start new, then kill old" ordering hit as an "address already in
+// use" failure on every real config. What differs between the
+// strategies is how the old pipeline is released and how much of a
+// safety net is kept for the new one.
+type ConfigReloadStrategy int
+
+const (
+	// Immediate shuts the running pipeline down and starts the new one
+	// right away, accepting a brief gap in accepted telemetry while the
+	// old receivers release their listeners and the new ones bind them.
+	Immediate ConfigReloadStrategy = iota
+
+	// Draining waits drainGracePeriod before shutting the old pipeline
+	// down, giving in-flight signals a chance to flush through its
+	// exporters, then starts the new one. This trades a longer gap in
+	// newly accepted telemetry for not dropping data already in flight
+	// through the old pipeline.
+	Draining
+
+	// Canary watches the new pipeline for canaryDuration after cutover
+	// and automatically restarts the config it replaced if the new one
+	// crashes during that window, rather than leaving the agent
+	// without a running pipeline until the next reload attempt. It
+	// does not mirror a percentage of live traffic to both pipelines:
+	// a single process can't bind the same receiver listeners twice,
+	// so there's no old pipeline left running to mirror to once the
+	// new one is serving.
+	Canary
+)
+
+// canaryDuration is how long Canary mode watches the newly cut-over
+// pipeline before discarding the config it replaced.
+const canaryDuration = 30 * time.Second
+
+// drainGracePeriod is how long Draining mode waits before shutting the
+// old pipeline down, so its exporters get a chance to flush whatever
+// was already in flight.
+const drainGracePeriod = 5 * time.Second
+
+// WithKubeAgentConfigReloadStrategy sets how the agent cuts over to a
+// newly fetched, validated configuration.
+func WithKubeAgentConfigReloadStrategy(s ConfigReloadStrategy) KubeOptions {
+	return func(h *KubeAgent) {
+		h.configReloadStrategy = s
+	}
+}
+
+// ConfigManager polls the Middleware backend for configuration updates,
+// validates them against the factories KubeAgent registers, and swaps
+// the running pipeline in place without restarting the collector
+// process. It keeps the last known-good config around so a bad fetch or
+// a pipeline that fails to start can be rolled back. Cutover itself
+// briefly stops the old pipeline before starting the new one, since both
+// would otherwise try to bind the same receiver listeners; see
+// ConfigReloadStrategy for how each strategy handles that gap.
+type ConfigManager struct {
+	agent    *KubeAgent
+	strategy ConfigReloadStrategy
+
+	httpClient *http.Client
+
+	mu           sync.Mutex
+	lastGoodYAML []byte
+	running      *runningPipeline
+}
+
+// NewConfigManager returns a ConfigManager bound to the given agent's
+// apiURLForConfigCheck and configured reload strategy.
+func NewConfigManager(k *KubeAgent) *ConfigManager {
+	return &ConfigManager{
+		agent:      k,
+		strategy:   k.configReloadStrategy,
+		httpClient: &http.Client{},
+	}
+}
+
+// Poll fetches the remote configuration over HTTP and applies it if it
+// differs from the last known-good config. Used when no OpAMP endpoint
+// is configured, or as a fallback when the OpAMP connection is down.
+func (m *ConfigManager) Poll(ctx context.Context) error {
+	yaml, err := m.fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("fetching remote config: %w", err)
+	}
+
+	return m.apply(ctx, yaml)
+}
+
+// ApplyRemoteConfig applies a config pushed directly by an OpAMP
+// RemoteConfig message, without re-fetching it over HTTP.
+func (m *ConfigManager) ApplyRemoteConfig(ctx context.Context, rc *protobufs.AgentRemoteConfig) error {
+	if rc == nil || rc.GetConfig() == nil {
+		return fmt.Errorf("empty OpAMP remote config")
+	}
+
+	yamlBytes, err := remoteConfigYAML(rc.GetConfig().GetConfigMap())
+	if err != nil {
+		return err
+	}
+
+	return m.apply(ctx, yamlBytes)
+}
+
+// remoteConfigYAML picks the agent's own config out of an OpAMP
+// AgentConfigMap. Servers conventionally key a monolithic config under
+// the empty string; fall back to the single entry when there's exactly
+// one, since some servers key it by filename instead. With more than one
+// named entry there's no way to tell which one is ours, so that's
+// treated as an error rather than picking one arbitrarily.
+func remoteConfigYAML(configMap map[string]*protobufs.AgentConfigFile) ([]byte, error) {
+	if file, ok := configMap[""]; ok && len(file.GetBody()) > 0 {
+		return file.GetBody(), nil
+	}
+
+	if len(configMap) == 1 {
+		for _, file := range configMap {
+			if len(file.GetBody()) > 0 {
+				return file.GetBody(), nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("OpAMP remote config contained %d files, expected a single \"\" entry", len(configMap))
+}
+
+// EffectiveConfigYAML returns the YAML of the currently running,
+// validated configuration, for reporting back to an OpAMP server via
+// UpdateEffectiveConfig.
+func (m *ConfigManager) EffectiveConfigYAML() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lastGoodYAML
+}
+
+// apply validates and swaps to the given configuration YAML if it
+// differs from the last known-good config. On validation failure it
+// leaves the currently running pipeline untouched; when the new pipeline
+// fails to start, it restarts the last known-good config. Either way it
+// returns the error.
+func (m *ConfigManager) apply(ctx context.Context, yaml []byte) error {
+	m.mu.Lock()
+	unchanged := string(yaml) == string(m.lastGoodYAML)
+	m.mu.Unlock()
+
+	if unchanged {
+		return nil
+	}
+
+	cfg, err := m.validate(ctx, yaml)
+	if err != nil {
+		m.agent.logger.Warn("rejecting remote config, keeping last known-good pipeline", zap.Error(err))
+		return fmt.Errorf("validating remote config: %w", err)
+	}
+
+	if err := m.swap(ctx, cfg); err != nil {
+		m.agent.logger.Warn("rolling back to last known-good pipeline after failed swap", zap.Error(err))
+
+		m.mu.Lock()
+		prevYAML := m.lastGoodYAML
+		m.mu.Unlock()
+
+		if len(prevYAML) > 0 {
+			if prevCfg, verr := m.validate(ctx, prevYAML); verr != nil {
+				m.agent.logger.Error("failed to roll back: re-parsing last known-good config", zap.Error(verr))
+			} else if rerr := m.swap(ctx, prevCfg); rerr != nil {
+				m.agent.logger.Error("failed to roll back: restarting last known-good config", zap.Error(rerr))
+			}
+		}
+
+		return fmt.Errorf("swapping pipeline: %w", err)
+	}
+
+	m.mu.Lock()
+	m.lastGoodYAML = yaml
+	m.mu.Unlock()
+
+	return nil
+}
+
+// fetch retrieves the effective configuration YAML from the Middleware
+// backend, using the agent's configured TLSConfig when set.
+func (m *ConfigManager) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.agent.apiURLForConfigCheck, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCfg, err := m.agent.tlsConfigFor(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS config for config-check client: %w", err)
+	}
+	if tlsCfg != nil {
+		m.httpClient.Transport = &http.Transport{TLSClientConfig: tlsCfg}
+	}
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+// validate runs otelcol.Config.Validate() against the candidate
+// configuration using the factories KubeAgent.GetFactories registers.
+func (m *ConfigManager) validate(ctx context.Context, yaml []byte) (*otelcol.Config, error) {
+	factories, err := m.agent.GetFactories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := unmarshalOtelConfig(yaml, factories)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// swap releases the old pipeline's receiver listeners, then brings up
+// the new pipeline and waits for it to report Ready. The old and new
+// otelcol.Collector instances can't be bound at the same time — they'd
+// fight over the same fixed receiver addresses — so unlike a hot
+// in-process reload of something like an HTTP handler, this always
+// leaves a brief gap in accepted telemetry around cutover. If the new
+// pipeline fails to come up, swap returns the error with no pipeline
+// running; apply then restarts the last known-good config.
+func (m *ConfigManager) swap(ctx context.Context, cfg *otelcol.Config) error {
+	factories, err := m.agent.GetFactories(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	prev := m.running
+	prevYAML := m.lastGoodYAML
+	m.running = nil
+	m.mu.Unlock()
+
+	if prev != nil {
+		if m.strategy == Draining {
+			time.Sleep(drainGracePeriod)
+		}
+		prev.shutdown()
+	}
+
+	next, err := startPipeline(ctx, cfg, factories)
+	if err != nil {
+		return fmt.Errorf("starting new pipeline: %w", err)
+	}
+
+	if err := next.waitReady(ctx); err != nil {
+		next.shutdown()
+		return fmt.Errorf("new pipeline failed to become ready: %w", err)
+	}
+
+	m.mu.Lock()
+	m.running = next
+	m.mu.Unlock()
+
+	if m.strategy == Canary && prev != nil {
+		go m.watchCanary(next, prevYAML, factories)
+	}
+
+	return nil
+}
+
+// watchCanary waits canaryDuration to see whether the pipeline just cut
+// over to keeps running. If it crashes within that window, it restarts
+// the config it replaced instead of leaving the agent without a running
+// pipeline until the next reload attempt — the closest equivalent to a
+// canary rollback available without mirroring live traffic to two
+// bound pipelines at once.
+func (m *ConfigManager) watchCanary(next *runningPipeline, prevYAML []byte, factories otelcol.Factories) {
+	select {
+	case err := <-next.done:
+		m.mu.Lock()
+		current := m.running == next
+		m.mu.Unlock()
+		if !current {
+			// A later swap shut this pipeline down on purpose.
+			return
+		}
+
+		m.agent.logger.Warn("new pipeline crashed during canary window, rolling back", zap.Error(err))
+
+		cfg, err := unmarshalOtelConfig(prevYAML, factories)
+		if err != nil {
+			m.agent.logger.Error("failed to roll back after canary crash: re-parsing previous config", zap.Error(err))
+			return
+		}
+
+		restarted, err := startPipeline(context.Background(), cfg, factories)
+		if err != nil {
+			m.agent.logger.Error("failed to roll back after canary crash: restarting previous config", zap.Error(err))
+			return
+		}
+
+		m.mu.Lock()
+		m.running = restarted
+		m.lastGoodYAML = prevYAML
+		m.mu.Unlock()
+	case <-time.After(canaryDuration):
+	}
+}
+
+// unmarshalOtelConfig decodes a raw otel-config.yaml against the given
+// factories so it can be validated before being handed to a collector
+// pipeline.
+func unmarshalOtelConfig(yamlBytes []byte, factories otelcol.Factories) (*otelcol.Config, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(yamlBytes, &raw); err != nil {
+		return nil, fmt.Errorf("parsing otel config yaml: %w", err)
+	}
+
+	conf := confmap.NewFromStringMap(raw)
+
+	cfg, err := otelcol.Unmarshal(conf, factories)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshalling otel config: %w", err)
+	}
+
+	return cfg, nil
+}
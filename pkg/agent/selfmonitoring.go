@@ -0,0 +1,303 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configopaque"
+	"go.opentelemetry.io/collector/exporter"
+	"go.opentelemetry.io/collector/exporter/otlpexporter"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// defaultSelfMonitoringInterval is used when self-monitoring is enabled
+// without an explicit WithKubeAgentSelfMonitoringInterval.
+const defaultSelfMonitoringInterval = 60 * time.Second
+
+// selfMonitor owns the OTLP log and metrics exporters that carry the
+// agent's own zap logs and periodic runtime health stats back to the
+// Middleware backend, tagged with the same resource attributes so both
+// can be correlated on one fleet-health dashboard. It doesn't emit
+// traces: the agent doesn't generate any spans of its own to export.
+type selfMonitor struct {
+	logs     exporter.Logs
+	metrics  exporter.Metrics
+	resource pcommon.Map
+	interval time.Duration
+	logger   *zap.Logger
+
+	cancel context.CancelFunc
+}
+
+// startSelfMonitoring creates and starts the internal OTLP log and
+// metrics exporters and kicks off the interval ticker that reports
+// runtime health stats over the metrics one. Callers must call stop()
+// to release both exporters.
+func startSelfMonitoring(ctx context.Context, k *KubeAgent) (*selfMonitor, error) {
+	factory := otlpexporter.NewFactory()
+
+	logsCfg, ok := factory.CreateDefaultConfig().(*otlpexporter.Config)
+	if !ok {
+		return nil, fmt.Errorf("unexpected otlpexporter default config type")
+	}
+	if err := configureSelfMonitoringExporter(ctx, k, logsCfg); err != nil {
+		return nil, err
+	}
+
+	logsSet := exporter.Settings{
+		ID:                component.NewID(factory.Type()),
+		TelemetrySettings: component.TelemetrySettings{Logger: k.logger},
+		BuildInfo:         component.NewDefaultBuildInfo(),
+	}
+
+	logsExporter, err := factory.CreateLogsExporter(ctx, logsSet, logsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating self-monitoring log exporter: %w", err)
+	}
+	if err := logsExporter.Start(ctx, componentHostNoop{}); err != nil {
+		return nil, fmt.Errorf("starting self-monitoring log exporter: %w", err)
+	}
+
+	metricsCfg, ok := factory.CreateDefaultConfig().(*otlpexporter.Config)
+	if !ok {
+		return nil, fmt.Errorf("unexpected otlpexporter default config type")
+	}
+	if err := configureSelfMonitoringExporter(ctx, k, metricsCfg); err != nil {
+		return nil, err
+	}
+
+	metricsSet := exporter.Settings{
+		ID:                component.NewIDWithName(factory.Type(), "self-monitoring"),
+		TelemetrySettings: component.TelemetrySettings{Logger: k.logger},
+		BuildInfo:         component.NewDefaultBuildInfo(),
+	}
+
+	metricsExporter, err := factory.CreateMetricsExporter(ctx, metricsSet, metricsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating self-monitoring metrics exporter: %w", err)
+	}
+	if err := metricsExporter.Start(ctx, componentHostNoop{}); err != nil {
+		return nil, fmt.Errorf("starting self-monitoring metrics exporter: %w", err)
+	}
+
+	interval := k.selfMonitoringInterval
+	if interval == 0 {
+		interval = defaultSelfMonitoringInterval
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+
+	m := &selfMonitor{
+		logs:     logsExporter,
+		metrics:  metricsExporter,
+		resource: selfMonitoringResourceAttributes(),
+		interval: interval,
+		logger:   k.logger,
+		cancel:   cancel,
+	}
+
+	k.logger = k.logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return wrapWithSelfMonitoringCore(core, m.resource, m.emitLogs)
+	}))
+
+	go m.runStatsLoop(runCtx)
+
+	return m, nil
+}
+
+// configureSelfMonitoringExporter points cfg at the agent's own target
+// and authenticates it the same way the generated otel-config.yaml's
+// otlpexporter does: a Bearer token built from the agent's apiKey, plus
+// whatever TLS material WithKubeAgentTLS configured. Without the auth
+// header every export is rejected by the Middleware backend.
+func configureSelfMonitoringExporter(ctx context.Context, k *KubeAgent, cfg *otlpexporter.Config) error {
+	cfg.ClientConfig.Endpoint = k.target
+	cfg.ClientConfig.Headers = map[string]configopaque.String{
+		"Authorization": configopaque.String("Bearer " + k.apiKey),
+	}
+
+	tlsCfg, err := k.tlsConfigFor(ctx)
+	if err != nil {
+		return fmt.Errorf("loading TLS config for self-monitoring exporter: %w", err)
+	}
+	if tlsCfg != nil {
+		cfg.ClientConfig.TLSSetting.CAFile = k.tlsConfig.CAFile
+		cfg.ClientConfig.TLSSetting.CertFile = k.tlsConfig.CertFile
+		cfg.ClientConfig.TLSSetting.KeyFile = k.tlsConfig.KeyFile
+		cfg.ClientConfig.TLSSetting.InsecureSkipVerify = tlsCfg.InsecureSkipVerify
+		cfg.ClientConfig.TLSSetting.ServerNameOverride = tlsCfg.ServerName
+	}
+
+	return nil
+}
+
+// stop shuts down the runtime-stats ticker and both exporters.
+func (m *selfMonitor) stop(ctx context.Context) error {
+	m.cancel()
+
+	if err := m.logs.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	return m.metrics.Shutdown(ctx)
+}
+
+// emitLogs hands a batch of bridged zap logs to the internal OTLP log
+// exporter.
+func (m *selfMonitor) emitLogs(logs plog.Logs) {
+	if err := m.logs.ConsumeLogs(context.Background(), logs); err != nil {
+		m.logger.Warn("failed to export self-monitoring logs", zap.Error(err))
+	}
+}
+
+// emitMetrics hands a batch of runtime stats to the internal OTLP
+// metrics exporter.
+func (m *selfMonitor) emitMetrics(metrics pmetric.Metrics) {
+	if err := m.metrics.ConsumeMetrics(context.Background(), metrics); err != nil {
+		m.logger.Warn("failed to export self-monitoring metrics", zap.Error(err))
+	}
+}
+
+// runStatsLoop reports runtime health stats — heap usage, goroutine
+// count, GC pause count — as real otelcol gauge metrics on the
+// configured self-monitoring interval. These stand in for the
+// collector's own otelcol_receiver_accepted_*, otelcol_exporter_send_failed_*
+// and queue-depth metrics; exporting those too would mean instrumenting
+// ConfigManager's runningPipeline to read the live collector's own
+// telemetry, which isn't wired up yet.
+func (m *selfMonitor) runStatsLoop(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.emitMetrics(m.runtimeStatsMetrics())
+		}
+	}
+}
+
+func (m *selfMonitor) runtimeStatsMetrics() pmetric.Metrics {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	now := pcommon.NewTimestampFromTime(time.Now())
+
+	metrics := pmetric.NewMetrics()
+	rm := metrics.ResourceMetrics().AppendEmpty()
+	m.resource.CopyTo(rm.Resource().Attributes())
+	sm := rm.ScopeMetrics().AppendEmpty()
+
+	addGauge := func(name string, value int64) {
+		metric := sm.Metrics().AppendEmpty()
+		metric.SetName(name)
+		dp := metric.SetEmptyGauge().DataPoints().AppendEmpty()
+		dp.SetTimestamp(now)
+		dp.SetIntValue(value)
+	}
+
+	addGauge("otelcol_process_runtime_heap_alloc_bytes", int64(mem.HeapAlloc))
+	addGauge("otelcol_process_runtime_total_alloc_bytes", int64(mem.TotalAlloc))
+	addGauge("otelcol_process_goroutines", int64(runtime.NumGoroutine()))
+	addGauge("otelcol_process_gc_count", int64(mem.NumGC))
+
+	return metrics
+}
+
+// componentHostNoop satisfies component.Host for the self-monitoring
+// exporter, which doesn't depend on any extensions.
+type componentHostNoop struct{}
+
+func (componentHostNoop) GetExtensions() map[component.ID]component.Component { return nil }
+
+// selfMonitoringCore is a zapcore.Core that mirrors every entry written
+// through k.logger into a plog.Logs batch handed to sink.
+type selfMonitoringCore struct {
+	zapcore.Core
+	resource pcommon.Map
+	sink     func(plog.Logs)
+}
+
+// wrapWithSelfMonitoringCore installs sink as the destination for every
+// zap entry bridged to OTLP.
+func wrapWithSelfMonitoringCore(core zapcore.Core, resource pcommon.Map, sink func(plog.Logs)) zapcore.Core {
+	return &selfMonitoringCore{
+		Core:     core,
+		resource: resource,
+		sink:     sink,
+	}
+}
+
+// Check adds c itself, not the wrapped core, to the checked entry so
+// that Write below sees every enabled entry.
+func (c *selfMonitoringCore) Check(entry zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return ce.AddCore(entry, c)
+	}
+	return ce
+}
+
+// With keeps the bridge in place on child loggers created with fields.
+func (c *selfMonitoringCore) With(fields []zapcore.Field) zapcore.Core {
+	return &selfMonitoringCore{Core: c.Core.With(fields), resource: c.resource, sink: c.sink}
+}
+
+// Write forwards the entry to the wrapped core and additionally bridges
+// it into a plog.Logs batch for export over the internal OTLP pipeline.
+func (c *selfMonitoringCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	logs := plog.NewLogs()
+	rl := logs.ResourceLogs().AppendEmpty()
+	c.resource.CopyTo(rl.Resource().Attributes())
+
+	record := rl.ScopeLogs().AppendEmpty().LogRecords().AppendEmpty()
+	record.SetTimestamp(pcommon.NewTimestampFromTime(entry.Time))
+	record.SetSeverityText(entry.Level.String())
+	record.Body().SetStr(entry.Message)
+
+	c.sink(logs)
+
+	return c.Core.Write(entry, fields)
+}
+
+// selfMonitoringResourceAttributes tags self-monitoring telemetry with
+// the agent's instance id and the cluster/node it's running on, mirroring
+// the resource attributes the Dash0 operator attaches to its own
+// self-monitoring signals.
+func selfMonitoringResourceAttributes() pcommon.Map {
+	m := pcommon.NewMap()
+	m.PutStr("service.instance.id", selfMonitoringInstanceID())
+
+	if node := os.Getenv("K8S_NODE_NAME"); node != "" {
+		m.PutStr("k8s.node.name", node)
+	}
+	if cluster := os.Getenv("K8S_CLUSTER_NAME"); cluster != "" {
+		m.PutStr("k8s.cluster.name", cluster)
+	}
+
+	return m
+}
+
+// selfMonitoringInstanceID derives a stable instance id for the running
+// agent pod, falling back to the pod hostname.
+func selfMonitoringInstanceID() string {
+	if uid := os.Getenv("K8S_POD_UID"); uid != "" {
+		return uid
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+
+	return host
+}
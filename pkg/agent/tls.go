@@ -0,0 +1,294 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// TLSConfig configures TLS/mTLS for every Middleware-bound connection
+// the agent makes: the otlpexporter/otlphttpexporter pipelines, the
+// OpAMP client, and the periodic config-check HTTP client. This lets
+// operators in air-gapped or zero-trust environments point the agent at
+// an internal gateway fronted by a private CA.
+type TLSConfig struct {
+	// CAFile is the path to a PEM-encoded CA bundle used to verify the
+	// Middleware gateway's certificate.
+	CAFile string
+
+	// CertFile and KeyFile are the PEM-encoded client certificate and
+	// key presented for mTLS.
+	CertFile string
+	KeyFile  string
+
+	// InsecureSkipVerify disables server certificate verification. Only
+	// intended for local testing.
+	InsecureSkipVerify bool
+
+	// ServerName overrides the server name used for certificate
+	// verification, e.g. when connecting through an IP or a Service
+	// name that doesn't match the certificate's SAN.
+	ServerName string
+}
+
+// WithKubeAgentTLS sets the TLS configuration used for all
+// Middleware-bound connections: the otel exporters, the OpAMP client
+// and the config-check HTTP client.
+func WithKubeAgentTLS(cfg TLSConfig) KubeOptions {
+	return func(h *KubeAgent) {
+		h.tlsConfig = &cfg
+	}
+}
+
+// tlsWatcher reloads the client certificate and CA bundle referenced by
+// a TLSConfig when the underlying files change, e.g. because a mounted
+// Kubernetes Secret was rotated. It hands out *tls.Config values with a
+// GetClientCertificate hook, so in-flight connections keep working while
+// new handshakes pick up a rotated client certificate. The CA bundle is
+// copied into each *tls.Config when it is built, so a rotated CA bundle
+// applies to *tls.Config values built after the rotation.
+type tlsWatcher struct {
+	cfg TLSConfig
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+
+	watcher *fsnotify.Watcher
+	logger  logFunc
+}
+
+// logFunc lets tlsWatcher log through *zap.Logger without importing zap
+// into every caller that only needs a notification hook (tests, mainly).
+type logFunc func(msg string, err error)
+
+// newTLSWatcher loads the initial cert/CA material and starts watching
+// the backing files for rotation. Callers must call Close when done.
+func newTLSWatcher(cfg TLSConfig, logger logFunc) (*tlsWatcher, error) {
+	w := &tlsWatcher{cfg: cfg, logger: logger}
+
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("creating cert filesystem watcher: %w", err)
+	}
+
+	for _, f := range []string{cfg.CAFile, cfg.CertFile, cfg.KeyFile} {
+		if f == "" {
+			continue
+		}
+		if err := fw.Add(f); err != nil {
+			fw.Close()
+			return nil, fmt.Errorf("watching %s: %w", f, err)
+		}
+	}
+
+	w.watcher = fw
+	go w.run()
+
+	return w, nil
+}
+
+func (w *tlsWatcher) run() {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			if err := w.reload(); err != nil && w.logger != nil {
+				w.logger("failed to reload rotated TLS material", err)
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			if w.logger != nil {
+				w.logger("TLS cert watcher error", err)
+			}
+		}
+	}
+}
+
+func (w *tlsWatcher) reload() error {
+	var cert tls.Certificate
+	if w.cfg.CertFile != "" && w.cfg.KeyFile != "" {
+		c, err := tls.LoadX509KeyPair(w.cfg.CertFile, w.cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading client certificate: %w", err)
+		}
+		cert = c
+	}
+
+	var pool *x509.CertPool
+	if w.cfg.CAFile != "" {
+		pem, err := os.ReadFile(w.cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("reading CA bundle: %w", err)
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", w.cfg.CAFile)
+		}
+	}
+
+	w.mu.Lock()
+	w.cert = &cert
+	w.pool = pool
+	w.mu.Unlock()
+
+	return nil
+}
+
+// TLSConfig builds a *tls.Config whose client certificate always
+// reflects the most recently loaded one, and whose CA bundle is the one
+// loaded at the time TLSConfig is called.
+func (w *tlsWatcher) TLSConfig() *tls.Config {
+	w.mu.RLock()
+	pool := w.pool
+	w.mu.RUnlock()
+
+	return &tls.Config{
+		ServerName:         w.cfg.ServerName,
+		InsecureSkipVerify: w.cfg.InsecureSkipVerify,
+		RootCAs:            pool,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			w.mu.RLock()
+			defer w.mu.RUnlock()
+			return w.cert, nil
+		},
+	}
+}
+
+// Close stops the filesystem watcher.
+func (w *tlsWatcher) Close() error {
+	if w.watcher == nil {
+		return nil
+	}
+	return w.watcher.Close()
+}
+
+// GetTLSConfig returns the agent's configured TLSConfig, or nil if none
+// was set via WithKubeAgentTLS. GetUpdatedYAMLPath uses this to merge a
+// tls: block into every otlp/otlphttp exporter of the generated
+// otel-config.yaml (see mergeTLSConfig), so the agent's own telemetry
+// egress is protected by the same CA bundle, client certificate and
+// server name override as the OpAMP and config-check HTTP clients (see
+// tlsConfigFor).
+func (k *KubeAgent) GetTLSConfig() *TLSConfig {
+	return k.tlsConfig
+}
+
+// mergeTLSConfig reads the otel-config template at templatePath and
+// injects a tls: block built from cfg into every otlpexporter/
+// otlphttpexporter entry under "exporters", so the agent's own
+// telemetry egress — not just the OpAMP and config-check HTTP clients —
+// is protected when WithKubeAgentTLS is set. It returns the path to the
+// merged file, mirroring mergeReceiverCreatorConfig in discovery.go.
+func mergeTLSConfig(templatePath string, cfg *TLSConfig) (string, error) {
+	base, err := os.ReadFile(templatePath)
+	if err != nil {
+		return "", fmt.Errorf("reading otel config template %s: %w", templatePath, err)
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(base, &doc); err != nil {
+		return "", fmt.Errorf("parsing otel config template %s: %w", templatePath, err)
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+
+	exporters, _ := doc["exporters"].(map[string]interface{})
+	if exporters == nil {
+		exporters = map[string]interface{}{}
+	}
+
+	tlsBlock := map[string]interface{}{
+		"insecure_skip_verify": cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		tlsBlock["ca_file"] = cfg.CAFile
+	}
+	if cfg.CertFile != "" {
+		tlsBlock["cert_file"] = cfg.CertFile
+	}
+	if cfg.KeyFile != "" {
+		tlsBlock["key_file"] = cfg.KeyFile
+	}
+	if cfg.ServerName != "" {
+		tlsBlock["server_name_override"] = cfg.ServerName
+	}
+
+	for name, raw := range exporters {
+		if !strings.HasPrefix(name, "otlp") {
+			continue
+		}
+
+		exporterCfg, _ := raw.(map[string]interface{})
+		if exporterCfg == nil {
+			exporterCfg = map[string]interface{}{}
+		}
+		exporterCfg["tls"] = tlsBlock
+		exporters[name] = exporterCfg
+	}
+	doc["exporters"] = exporters
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("marshalling merged otel config: %w", err)
+	}
+
+	mergedPath := strings.TrimSuffix(templatePath, ".yaml") + "-tls-generated.yaml"
+	if err := os.WriteFile(mergedPath, out, 0o644); err != nil {
+		return "", fmt.Errorf("writing merged otel config %s: %w", mergedPath, err)
+	}
+
+	return mergedPath, nil
+}
+
+// tlsConfigFor returns the *tls.Config to use for Middleware-bound
+// connections, or nil when the agent has no TLSConfig configured and
+// should use the collector's default transport security. It's safe to
+// call concurrently: the config-check HTTP client and the OpAMP client
+// both call this, and the lazily created tlsWatcher must only be
+// initialized once. Unlike sync.Once, a failed initialization (e.g. the
+// cert Secret isn't mounted yet at startup) is retried on the next call
+// instead of being cached forever.
+func (k *KubeAgent) tlsConfigFor(ctx context.Context) (*tls.Config, error) {
+	if k.tlsConfig == nil {
+		return nil, nil
+	}
+
+	k.tlsWatcherMu.Lock()
+	defer k.tlsWatcherMu.Unlock()
+
+	if k.tlsWatcher == nil {
+		logger := func(msg string, err error) {
+			k.logger.Sugar().Warnw(msg, "error", err)
+		}
+
+		w, err := newTLSWatcher(*k.tlsConfig, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		k.tlsWatcher = w
+	}
+
+	return k.tlsWatcher.TLSConfig(), nil
+}